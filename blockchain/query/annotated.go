@@ -55,8 +55,21 @@ type AnnotatedOutput struct {
 	AccountTags     *json.RawMessage   `json:"account_tags,omitempty"`
 	ControlProgram  chainjson.HexBytes `json:"control_program"`
 	ReferenceData   *json.RawMessage   `json:"reference_data"`
+	Status          string             `json:"status"`
 }
 
+// Output status values for AnnotatedOutput.Status. An output starts
+// unconfirmed when its transaction is submitted and moves to confirmed
+// once its block lands. A confirmed output is reserved while it is set
+// aside to fund a transaction that has not confirmed yet, and becomes
+// spent once the spend itself confirms.
+const (
+	StatusUnconfirmed = "unconfirmed"
+	StatusConfirmed   = "confirmed"
+	StatusReserved    = "reserved"
+	StatusSpent       = "spent"
+)
+
 type AnnotatedAccount struct {
 	ID     string           `json:"id"`
 	Alias  string           `json:"alias,omitempty"`
@@ -178,6 +191,7 @@ func buildAnnotatedOutput(tx *legacy.Tx, idx int) *AnnotatedOutput {
 		Amount:          orig.Amount,
 		ControlProgram:  orig.ControlProgram,
 		ReferenceData:   &emptyJSONObject,
+		Status:          StatusConfirmed,
 	}
 	if IsValidJSON(orig.ReferenceData) {
 		referenceData := json.RawMessage(orig.ReferenceData)
@@ -0,0 +1,125 @@
+package wallet
+
+import (
+	"encoding/json"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tendermint/tmlibs/db"
+
+	"github.com/bytom/blockchain/account"
+	"github.com/bytom/blockchain/asset"
+	"github.com/bytom/blockchain/query"
+	"github.com/bytom/crypto/sha3pool"
+	"github.com/bytom/protocol/bc"
+	"github.com/bytom/protocol/bc/legacy"
+)
+
+// annotationCacheSize bounds the account/asset LRU caches used while
+// annotating a block's worth of transactions; a block rarely touches
+// more distinct accounts or assets than this.
+const annotationCacheSize = 1024
+
+// BuildAnnotatedTransaction resolves every account/asset alias and tag
+// the wallet has on record for orig, and persists the result in batch.
+func (w *Wallet) BuildAnnotatedTransaction(batch *db.Batch, orig *legacy.Tx, b *legacy.Block, indexInBlock uint32) *query.AnnotatedTx {
+	tx := query.BuildAnnotatedTransaction(orig, b, indexInBlock)
+	for _, in := range tx.Inputs {
+		w.annotateInput(in)
+	}
+	for _, out := range tx.Outputs {
+		w.annotateOutput(out)
+	}
+
+	if err := w.saveAnnotatedTransaction(batch, tx); err != nil {
+		log.WithField("err", err).Error("saving annotated transaction")
+	}
+
+	return tx
+}
+
+func (w *Wallet) annotateInput(in *query.AnnotatedInput) {
+	if acc, ok := w.accountByControlProgram(in.ControlProgram); ok {
+		in.AccountID = acc.ID
+		in.AccountAlias = acc.Alias
+		in.AccountTags = acc.Tags
+	}
+	if a, ok := w.assetByID(in.AssetID); ok {
+		in.AssetAlias = a.Alias
+		in.AssetDefinition = a.Definition
+		in.AssetTags = a.Tags
+	}
+}
+
+func (w *Wallet) annotateOutput(out *query.AnnotatedOutput) {
+	if acc, ok := w.accountByControlProgram(out.ControlProgram); ok {
+		out.AccountID = acc.ID
+		out.AccountAlias = acc.Alias
+		out.AccountTags = acc.Tags
+	}
+	if a, ok := w.assetByID(out.AssetID); ok {
+		out.AssetAlias = a.Alias
+		out.AssetDefinition = a.Definition
+		out.AssetTags = a.Tags
+	}
+	out.Status = w.outputStatus(out.OutputID)
+}
+
+// accountByControlProgram mirrors loadAccountInfo's sha3 lookup.
+func (w *Wallet) accountByControlProgram(controlProgram []byte) (*account.Account, bool) {
+	if len(controlProgram) == 0 || !w.cpFilter.mayContain(controlProgram) {
+		return nil, false
+	}
+
+	var hash [32]byte
+	sha3pool.Sum256(hash[:], controlProgram)
+	rawCP := w.DB.Get(account.AccountCPKey(hash))
+	if rawCP == nil {
+		return nil, false
+	}
+
+	cp := account.CtrlProgram{}
+	if err := json.Unmarshal(rawCP, &cp); err != nil {
+		return nil, false
+	}
+
+	return w.accountByID(cp.AccountID)
+}
+
+func (w *Wallet) accountByID(accountID string) (*account.Account, bool) {
+	if v, ok := w.accountCache.Get(accountID); ok {
+		return v.(*account.Account), true
+	}
+
+	rawAccount := w.DB.Get(account.AccountKey(accountID))
+	if rawAccount == nil {
+		return nil, false
+	}
+
+	acc := &account.Account{}
+	if err := json.Unmarshal(rawAccount, acc); err != nil {
+		return nil, false
+	}
+
+	w.accountCache.Add(accountID, acc)
+	return acc, true
+}
+
+func (w *Wallet) assetByID(assetID bc.AssetID) (*asset.Asset, bool) {
+	cacheKey := assetID.String()
+	if v, ok := w.assetCache.Get(cacheKey); ok {
+		return v.(*asset.Asset), true
+	}
+
+	rawAsset := w.DB.Get(asset.Key(assetID))
+	if rawAsset == nil {
+		return nil, false
+	}
+
+	a := &asset.Asset{}
+	if err := json.Unmarshal(rawAsset, a); err != nil {
+		return nil, false
+	}
+
+	w.assetCache.Add(cacheKey, a)
+	return a, true
+}
@@ -0,0 +1,199 @@
+package wallet
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/bytom/crypto/sha3pool"
+)
+
+const (
+	//defaultCPFilterCapacity is the default Bloom filter capacity.
+	defaultCPFilterCapacity = 100000
+	//defaultCPFilterFPRate is the default Bloom filter false-positive rate.
+	defaultCPFilterFPRate = 0.01
+	//cpFilterLoadFactor is the load factor that triggers a filter regrow.
+	cpFilterLoadFactor = 0.75
+)
+
+// CPFilterConfig controls the size and accuracy of the control-program
+// Bloom filter.
+type CPFilterConfig struct {
+	Capacity uint32
+	FPRate   float64
+}
+
+// cpFilter is a Bloom filter over sha3-256 hashes of control programs. It
+// keeps the hashes it was built from so it can grow itself in place.
+type cpFilter struct {
+	mu       sync.RWMutex
+	bits     []byte
+	m        uint32
+	k        uint32
+	n        uint32
+	capacity uint32
+	fpRate   float64
+	hashes   [][32]byte
+}
+
+func newCPFilter(capacity uint32, fpRate float64) *cpFilter {
+	if capacity == 0 {
+		capacity = defaultCPFilterCapacity
+	}
+	if fpRate <= 0 {
+		fpRate = defaultCPFilterFPRate
+	}
+
+	m, k := bloomParams(capacity, fpRate)
+	return &cpFilter{
+		bits:     make([]byte, (m+7)/8),
+		m:        m,
+		k:        k,
+		capacity: capacity,
+		fpRate:   fpRate,
+	}
+}
+
+// bloomParams picks the bit-array size and hash-function count for n
+// items at the target false-positive rate.
+func bloomParams(n uint32, fpRate float64) (m, k uint32) {
+	mf := -float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2)
+	m = uint32(math.Ceil(mf))
+	if m == 0 {
+		m = 1
+	}
+	k = uint32(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k == 0 {
+		k = 1
+	}
+	return m, k
+}
+
+// locations derives the k bit positions for hash via double hashing
+// (Kirsch-Mitzenmacher).
+func (f *cpFilter) locations(hash [32]byte) []uint32 {
+	h1 := binary.BigEndian.Uint32(hash[0:4])
+	h2 := binary.BigEndian.Uint32(hash[4:8])
+
+	locs := make([]uint32, f.k)
+	for i := uint32(0); i < f.k; i++ {
+		locs[i] = (h1 + i*h2) % f.m
+	}
+	return locs
+}
+
+func (f *cpFilter) add(controlProgram []byte) {
+	var hash [32]byte
+	sha3pool.Sum256(hash[:], controlProgram)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.setHash(hash)
+	f.hashes = append(f.hashes, hash)
+	f.n++
+}
+
+func (f *cpFilter) setHash(hash [32]byte) {
+	for _, loc := range f.locations(hash) {
+		f.bits[loc/8] |= 1 << (loc % 8)
+	}
+}
+
+// mayContain reports whether controlProgram could be in the filter. A
+// false result is definitive; a true result still needs a LevelDB check.
+// An empty filter always reports true.
+func (f *cpFilter) mayContain(controlProgram []byte) bool {
+	var hash [32]byte
+	sha3pool.Sum256(hash[:], controlProgram)
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if f.n == 0 {
+		return true
+	}
+	for _, loc := range f.locations(hash) {
+		if f.bits[loc/8]&(1<<(loc%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// loadFactor returns how full the filter is relative to its capacity.
+func (f *cpFilter) loadFactor() float64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return float64(f.n) / float64(f.capacity)
+}
+
+// growIfNeeded doubles the filter's capacity and rehashes every control
+// program it has seen once the load factor crosses cpFilterLoadFactor.
+func (f *cpFilter) growIfNeeded() {
+	f.mu.Lock()
+	if float64(f.n)/float64(f.capacity) <= cpFilterLoadFactor {
+		f.mu.Unlock()
+		return
+	}
+	newCapacity := f.capacity * 2
+	hashes := f.hashes
+	fpRate := f.fpRate
+	f.mu.Unlock()
+
+	m, k := bloomParams(newCapacity, fpRate)
+	grown := &cpFilter{
+		bits:     make([]byte, (m+7)/8),
+		m:        m,
+		k:        k,
+		capacity: newCapacity,
+		fpRate:   fpRate,
+		hashes:   hashes,
+		n:        uint32(len(hashes)),
+	}
+	for _, hash := range hashes {
+		grown.setHash(hash)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.bits = grown.bits
+	f.m = grown.m
+	f.k = grown.k
+	f.capacity = grown.capacity
+	log.WithField("capacity", newCapacity).Info("grew control-program bloom filter")
+}
+
+// ConfigureCPFilter sets the target capacity and false-positive rate for
+// w's control-program Bloom filter and rebuilds it empty. Call this
+// before RebuildCPFilter if the defaults don't fit.
+func (w *Wallet) ConfigureCPFilter(cfg CPFilterConfig) {
+	w.cpFilter = newCPFilter(cfg.Capacity, cfg.FPRate)
+}
+
+// RebuildCPFilter replaces w's control-program Bloom filter with a fresh
+// one populated from controlPrograms. NewWallet calls this on open.
+func (w *Wallet) RebuildCPFilter(controlPrograms [][]byte) {
+	fpRate := defaultCPFilterFPRate
+	capacity := uint32(len(controlPrograms))
+	if w.cpFilter != nil {
+		fpRate = w.cpFilter.fpRate
+		if w.cpFilter.capacity > capacity {
+			capacity = w.cpFilter.capacity
+		}
+	}
+
+	f := newCPFilter(capacity, fpRate)
+	for _, cp := range controlPrograms {
+		f.add(cp)
+	}
+	w.cpFilter = f
+}
+
+// AddControlProgram adds a newly persisted control program to w's Bloom
+// filter, growing it first if it's gotten too full.
+func (w *Wallet) AddControlProgram(controlProgram []byte) {
+	w.cpFilter.growIfNeeded()
+	w.cpFilter.add(controlProgram)
+}
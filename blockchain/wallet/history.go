@@ -0,0 +1,124 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tendermint/tmlibs/db"
+
+	"github.com/bytom/blockchain/query"
+	"github.com/bytom/errors"
+)
+
+const (
+	//txPrefix stores the full annotated transaction, keyed by its
+	//position in the chain so lookups by (block_height, position) are a
+	//single point read.
+	txPrefix = "Tx:"
+	//accountTxIndexPrefix orders an account's transactions by
+	//confirmation time so ListTransactions can page through history with
+	//a prefix scan instead of a full store walk.
+	accountTxIndexPrefix = "AccountTx:"
+)
+
+// txKey builds the primary key for the annotated transaction confirmed at
+// (blockHeight, position).
+func txKey(blockHeight uint64, position uint32) []byte {
+	key := make([]byte, len(txPrefix)+8+4)
+	copy(key, txPrefix)
+	binary.BigEndian.PutUint64(key[len(txPrefix):], blockHeight)
+	binary.BigEndian.PutUint32(key[len(txPrefix)+8:], position)
+	return key
+}
+
+// accountTxIndexPrefixKey returns the shared prefix for every transaction
+// accountID appears in.
+func accountTxIndexPrefixKey(accountID string) []byte {
+	return []byte(accountTxIndexPrefix + accountID + ":")
+}
+
+// accountTxIndexKey builds the secondary index key
+// AccountTx/<account_id>/<timestamp_be>/<block_height_be>/<position_be>,
+// pointing at the primary txKey for the same transaction.
+func accountTxIndexKey(accountID string, timestamp time.Time, blockHeight uint64, position uint32) []byte {
+	prefix := accountTxIndexPrefixKey(accountID)
+	key := make([]byte, len(prefix)+8+8+4)
+	n := copy(key, prefix)
+	binary.BigEndian.PutUint64(key[n:], uint64(timestamp.UnixNano()))
+	binary.BigEndian.PutUint64(key[n+8:], blockHeight)
+	binary.BigEndian.PutUint32(key[n+16:], position)
+	return key
+}
+
+// saveAnnotatedTransaction persists tx under its primary (block_height,
+// position) key, plus one (account_id, timestamp) index entry for every
+// account the transaction's inputs or outputs touch, all in batch so the
+// caller can commit it alongside its other wallet-state writes.
+func (w *Wallet) saveAnnotatedTransaction(batch *db.Batch, tx *query.AnnotatedTx) error {
+	rawTx, err := json.Marshal(tx)
+	if err != nil {
+		return errors.Wrap(err, "marshal annotated transaction")
+	}
+
+	primaryKey := txKey(tx.BlockHeight, tx.Position)
+	(*batch).Set(primaryKey, rawTx)
+
+	for accountID := range txAccountIDs(tx) {
+		(*batch).Set(accountTxIndexKey(accountID, tx.Timestamp, tx.BlockHeight, tx.Position), primaryKey)
+	}
+
+	return nil
+}
+
+func txAccountIDs(tx *query.AnnotatedTx) map[string]bool {
+	ids := make(map[string]bool)
+	for _, in := range tx.Inputs {
+		if in.AccountID != "" {
+			ids[in.AccountID] = true
+		}
+	}
+	for _, out := range tx.Outputs {
+		if out.AccountID != "" {
+			ids[out.AccountID] = true
+		}
+	}
+	return ids
+}
+
+// ListTransactions returns up to limit of accountID's transactions
+// confirmed after the given cursor, oldest-of-the-page first. Pass the
+// Timestamp, BlockHeight and Position of the last transaction from a
+// previous page as after/afterHeight/afterPosition to continue paging;
+// a bare timestamp isn't enough to dedupe rows within the same block.
+func (w *Wallet) ListTransactions(accountID string, after time.Time, afterHeight uint64, afterPosition uint32, limit int) ([]*query.AnnotatedTx, error) {
+	txs := make([]*query.AnnotatedTx, 0, limit)
+
+	prefix := accountTxIndexPrefixKey(accountID)
+	startKey := accountTxIndexKey(accountID, after, afterHeight, afterPosition)
+
+	iter := w.DB.IteratorPrefix(prefix)
+	defer iter.Release()
+
+	for iter.Next() && (limit <= 0 || len(txs) < limit) {
+		if bytes.Compare(iter.Key(), startKey) <= 0 {
+			continue
+		}
+
+		rawTx := w.DB.Get(iter.Value())
+		if rawTx == nil {
+			continue
+		}
+
+		tx := &query.AnnotatedTx{}
+		if err := json.Unmarshal(rawTx, tx); err != nil {
+			log.WithField("err", err).Error("listing transactions: unmarshal")
+			continue
+		}
+		txs = append(txs, tx)
+	}
+
+	return txs, nil
+}
@@ -1,16 +1,17 @@
 package wallet
 
 import (
+	"encoding/hex"
 	"encoding/json"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/tendermint/tmlibs/db"
 
+	"github.com/bytom/blockchain/account"
 	"github.com/bytom/crypto/sha3pool"
 	"github.com/bytom/errors"
 	"github.com/bytom/protocol/bc"
 	"github.com/bytom/protocol/bc/legacy"
-	"github.com/bytom/blockchain/account"
 )
 
 type rawOutput struct {
@@ -31,7 +32,7 @@ type accountOutput struct {
 	change    bool
 }
 
-//ReverseAccountUTXOs process the invalid blocks when orphan block rollback
+// ReverseAccountUTXOs process the invalid blocks when orphan block rollback
 func (w *Wallet) ReverseAccountUTXOs(batch *db.Batch, b *legacy.Block) {
 	var err error
 
@@ -80,20 +81,23 @@ func (w *Wallet) ReverseAccountUTXOs(batch *db.Batch, b *legacy.Block) {
 				continue
 			}
 			//delete new UTXOs
-			(*batch).Delete(account.AccountUTXOKey(string(resOutID.Bytes())))
+			if u := w.deleteAccountUTXO(batch, *resOutID); u != nil {
+				w.demoteConfirmedUTXO(batch, u)
+			}
 		}
 	}
 
 }
 
-//BuildAccountUTXOs process valid blocks to build account unspent outputs db
+// BuildAccountUTXOs process valid blocks to build account unspent outputs db
 func (w *Wallet) BuildAccountUTXOs(batch *db.Batch, b *legacy.Block) {
 	var err error
 
 	//handle spent UTXOs
 	delOutputIDs := prevoutDBKeys(b.Transactions...)
 	for _, delOutputID := range delOutputIDs {
-		(*batch).Delete(account.AccountUTXOKey(string(delOutputID.Bytes())))
+		w.releaseReservation(batch, delOutputID)
+		w.deleteAccountUTXO(batch, delOutputID)
 	}
 
 	//handle new UTXOs
@@ -105,6 +109,7 @@ func (w *Wallet) BuildAccountUTXOs(batch *db.Batch, b *legacy.Block) {
 			if !ok {
 				continue
 			}
+			w.promotePendingUTXO(batch, *tx.OutputID(j))
 			out := &rawOutput{
 				OutputID:       *tx.OutputID(j),
 				AssetAmount:    out.AssetAmount,
@@ -152,6 +157,11 @@ func (w *Wallet) loadAccountInfo(outs []*rawOutput) []*accountOutput {
 
 	var hash [32]byte
 	for s := range outsByScript {
+		if !w.cpFilter.mayContain([]byte(s)) {
+			//definitely not one of ours; skip the LevelDB read entirely
+			continue
+		}
+
 		sha3pool.Sum256(hash[:], []byte(s))
 		bytes := w.DB.Get(account.AccountCPKey(hash))
 		if bytes == nil {
@@ -206,7 +216,35 @@ func (w *Wallet) upsertConfirmedAccountOutputs(outs []*accountOutput, block *leg
 		}
 
 		(*batch).Set(account.AccountUTXOKey(string(u.OutputID)), rawUTXO)
+		assetID := hex.EncodeToString(u.AssetID)
+		(*batch).Set(accountUTXOIndexKey(u.AccountID, assetID, u.Amount, string(u.OutputID)), u.OutputID)
+		(*batch).Set(assetIndexKey(assetID, string(u.OutputID)), u.OutputID)
 	}
 	return nil
 }
 
+// deleteAccountUTXO removes the primary UTXO record and both secondary
+// indexes for outputID in the same batch, keeping them consistent with
+// each other even when the deletion is driven by a reorg
+// (ReverseAccountUTXOs) rather than a normal spend.
+func (w *Wallet) deleteAccountUTXO(batch *db.Batch, outputID bc.Hash) *account.UTXO {
+	key := account.AccountUTXOKey(string(outputID.Bytes()))
+	rawUTXO := w.DB.Get(key)
+	if rawUTXO == nil {
+		(*batch).Delete(key)
+		return nil
+	}
+
+	u := &account.UTXO{}
+	if err := json.Unmarshal(rawUTXO, u); err != nil {
+		log.WithField("err", err).Error("deleting account utxo: unmarshal")
+		(*batch).Delete(key)
+		return nil
+	}
+
+	assetID := hex.EncodeToString(u.AssetID)
+	(*batch).Delete(key)
+	(*batch).Delete(accountUTXOIndexKey(u.AccountID, assetID, u.Amount, string(u.OutputID)))
+	(*batch).Delete(assetIndexKey(assetID, string(u.OutputID)))
+	return u
+}
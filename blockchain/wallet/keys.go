@@ -0,0 +1,70 @@
+package wallet
+
+import "encoding/binary"
+
+const (
+	//accountUTXOIndexPrefix groups unspent outputs by account and asset so
+	//that ListUnspentOutputs can walk a single prefix range instead of
+	//scanning every UTXO the wallet knows about.
+	accountUTXOIndexPrefix = "AccountUTXO:"
+	//assetIndexPrefix groups unspent outputs by asset only, for callers
+	//that don't care which account an output belongs to.
+	assetIndexPrefix = "Asset:"
+)
+
+// accountUTXOIndexKey builds the secondary index key
+// AccountUTXO/<account_id>/<asset_id>/<amount_be>/<outputID>.
+// The amount is encoded big-endian but bit-inverted so that a forward
+// prefix scan visits outputs in descending-amount order, letting
+// coin-selection walk straight into the largest UTXOs without ever
+// loading the full set into memory.
+func accountUTXOIndexKey(accountID, assetID string, amount uint64, outputID string) []byte {
+	key := make([]byte, 0, len(accountUTXOIndexPrefix)+len(accountID)+len(assetID)+8+len(outputID)+2)
+	key = append(key, accountUTXOIndexPrefix...)
+	key = append(key, accountID...)
+	key = append(key, ':')
+	key = append(key, assetID...)
+	key = append(key, ':')
+	key = append(key, descendingAmountBytes(amount)...)
+	key = append(key, outputID...)
+	return key
+}
+
+// accountUTXOIndexPrefixKey returns the shared prefix for every UTXO the
+// given account holds of the given asset. Passing an empty assetID scopes
+// the prefix to the whole account across all assets.
+func accountUTXOIndexPrefixKey(accountID, assetID string) []byte {
+	key := make([]byte, 0, len(accountUTXOIndexPrefix)+len(accountID)+len(assetID)+2)
+	key = append(key, accountUTXOIndexPrefix...)
+	key = append(key, accountID...)
+	key = append(key, ':')
+	if assetID != "" {
+		key = append(key, assetID...)
+		key = append(key, ':')
+	}
+	return key
+}
+
+// assetIndexKey builds the secondary index key Asset/<asset_id>/<outputID>.
+func assetIndexKey(assetID, outputID string) []byte {
+	key := make([]byte, 0, len(assetIndexPrefix)+len(assetID)+len(outputID)+1)
+	key = append(key, assetIndexPrefix...)
+	key = append(key, assetID...)
+	key = append(key, ':')
+	key = append(key, outputID...)
+	return key
+}
+
+// descendingAmountBytes encodes amount as 8 big-endian bytes with every
+// bit flipped, so that byte-wise key comparison (and therefore a forward
+// LevelDB iterator) orders larger amounts first.
+func descendingAmountBytes(amount uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, ^amount)
+	return b
+}
+
+// decodeDescendingAmount reverses descendingAmountBytes.
+func decodeDescendingAmount(b []byte) uint64 {
+	return ^binary.BigEndian.Uint64(b)
+}
@@ -0,0 +1,366 @@
+package wallet
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tendermint/tmlibs/db"
+
+	"github.com/bytom/blockchain/account"
+	"github.com/bytom/blockchain/query"
+	"github.com/bytom/errors"
+	"github.com/bytom/protocol/bc"
+	"github.com/bytom/protocol/bc/legacy"
+)
+
+const (
+	pendingUTXOPrefix    = "PendingUTXO:"
+	pendingMetaPrefix    = "PendingMeta:"
+	reservationPrefix    = "Reservation:"
+	reservedOutputPrefix = "Reserved:"
+
+	//pendingUTXODefaultTTL is the TTL SubmitTx gives its pending records,
+	//and the TTL a demoted output gets, counted from its original
+	//submission time.
+	pendingUTXODefaultTTL = time.Hour
+)
+
+// PendingUTXO tracks a submitted output before it has confirmed on chain
+// (Status == query.StatusUnconfirmed). Reserved outputs are not tracked
+// here; they are recorded by a Reservation.
+type PendingUTXO struct {
+	OutputID       []byte    `json:"output_id"`
+	AccountID      string    `json:"account_id"`
+	AssetID        string    `json:"asset_id"`
+	Amount         uint64    `json:"amount"`
+	ControlProgram []byte    `json:"control_program"`
+	Status         string    `json:"status"`
+	SubmittedAt    time.Time `json:"submitted_at"`
+	ExpiresAt      time.Time `json:"expires_at"`
+}
+
+// pendingMeta preserves SubmittedAt across promotion so a later reorg
+// can demote the output without losing when it was first broadcast.
+type pendingMeta struct {
+	SubmittedAt time.Time `json:"submitted_at"`
+}
+
+// Reservation records a set of UTXOs set aside to fund a transaction
+// that's still being built.
+type Reservation struct {
+	ID        string    `json:"id"`
+	AccountID string    `json:"account_id"`
+	AssetID   string    `json:"asset_id"`
+	Amount    uint64    `json:"amount"`
+	OutputIDs []string  `json:"output_ids"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func pendingUTXOKey(outputID string) []byte {
+	return []byte(pendingUTXOPrefix + outputID)
+}
+
+func pendingMetaKey(outputID string) []byte {
+	return []byte(pendingMetaPrefix + outputID)
+}
+
+func reservationKey(reservationID string) []byte {
+	return []byte(reservationPrefix + reservationID)
+}
+
+func reservedOutputKey(outputID string) []byte {
+	return []byte(reservedOutputPrefix + outputID)
+}
+
+func newReservationID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// MarkUnconfirmedTx records tx's own outputs as unconfirmed account UTXOs
+// and reserves whichever of its inputs spend the wallet's own confirmed
+// UTXOs, in the same batch the caller uses to submit the transaction.
+func (w *Wallet) MarkUnconfirmedTx(batch *db.Batch, tx *legacy.Tx, ttl time.Duration) error {
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+
+	for j, out := range tx.Outputs {
+		resOutID := tx.ResultIds[j]
+		if _, ok := tx.Entries[*resOutID].(*bc.Output); !ok {
+			continue
+		}
+
+		raw := &rawOutput{
+			OutputID:       *tx.OutputID(j),
+			AssetAmount:    out.AssetAmount,
+			ControlProgram: out.ControlProgram,
+		}
+		for _, acc := range w.loadAccountInfo([]*rawOutput{raw}) {
+			outputID := acc.OutputID.Bytes()
+			p := &PendingUTXO{
+				OutputID:       outputID,
+				AccountID:      acc.AccountID,
+				AssetID:        hex.EncodeToString(acc.AssetId.Bytes()),
+				Amount:         acc.Amount,
+				ControlProgram: acc.ControlProgram,
+				Status:         query.StatusUnconfirmed,
+				SubmittedAt:    now,
+				ExpiresAt:      expiresAt,
+			}
+			rawP, err := json.Marshal(p)
+			if err != nil {
+				return errors.Wrap(err, "marshal pending utxo")
+			}
+			(*batch).Set(pendingUTXOKey(string(outputID)), rawP)
+
+			rawMeta, err := json.Marshal(&pendingMeta{SubmittedAt: now})
+			if err != nil {
+				return errors.Wrap(err, "marshal pending meta")
+			}
+			(*batch).Set(pendingMetaKey(string(outputID)), rawMeta)
+		}
+	}
+
+	var spentOutputIDs []string
+	for _, inpID := range tx.Tx.InputIDs {
+		sp, err := tx.Spend(inpID)
+		if err != nil {
+			continue
+		}
+		if w.DB.Get(account.AccountUTXOKey(string(sp.SpentOutputId.Bytes()))) == nil {
+			//not one of ours
+			continue
+		}
+		spentOutputIDs = append(spentOutputIDs, string(sp.SpentOutputId.Bytes()))
+	}
+
+	if len(spentOutputIDs) == 0 {
+		return nil
+	}
+
+	_, err := w.writeReservation(batch, "", "", 0, spentOutputIDs, expiresAt)
+	return err
+}
+
+// SubmitTx marks tx's own outputs unconfirmed and reserves whichever of
+// its inputs spend the wallet's own confirmed UTXOs. Call this at the
+// point a signed transaction is handed off for broadcast.
+func (w *Wallet) SubmitTx(tx *legacy.Tx) error {
+	batch := w.DB.NewBatch()
+	if err := w.MarkUnconfirmedTx(&batch, tx, pendingUTXODefaultTTL); err != nil {
+		return err
+	}
+	batch.Write()
+	return nil
+}
+
+// outputStatus reports outputID's status for annotation: unconfirmed
+// while it's tracked as a PendingUTXO, reserved while a reservation holds
+// it, confirmed once it's a live account UTXO, and spent once it's none
+// of these.
+func (w *Wallet) outputStatus(outputID bc.Hash) string {
+	id := string(outputID.Bytes())
+
+	if raw := w.DB.Get(pendingUTXOKey(id)); raw != nil {
+		p := &PendingUTXO{}
+		if err := json.Unmarshal(raw, p); err == nil {
+			return p.Status
+		}
+	}
+	if w.isReserved(id) {
+		return query.StatusReserved
+	}
+	if w.DB.Get(account.AccountUTXOKey(id)) != nil {
+		return query.StatusConfirmed
+	}
+	return query.StatusSpent
+}
+
+// ReserveUTXOs selects unspent, unreserved outputs of assetID held by
+// accountID totalling at least amount and marks them reserved until
+// expiresAt. Call CancelReservation to free them early.
+func (w *Wallet) ReserveUTXOs(accountID, assetID string, amount uint64, expiresAt time.Time) (*Reservation, error) {
+	//hold reserveMu for the whole select-then-mark sequence so two
+	//concurrent callers can't both pick the same unreserved UTXO.
+	w.reserveMu.Lock()
+	defer w.reserveMu.Unlock()
+
+	candidates, err := w.ListUnspentOutputs(accountID, assetID, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		outputIDs []string
+		reserved  uint64
+	)
+	for _, u := range candidates {
+		if reserved >= amount {
+			break
+		}
+		if w.isReserved(string(u.OutputID)) {
+			continue
+		}
+		outputIDs = append(outputIDs, string(u.OutputID))
+		reserved += u.Amount
+	}
+
+	if reserved < amount {
+		return nil, errors.New("insufficient unreserved utxos to satisfy reservation")
+	}
+
+	batch := w.DB.NewBatch()
+	res, err := w.writeReservation(&batch, accountID, assetID, reserved, outputIDs, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	batch.Write()
+	return res, nil
+}
+
+// CancelReservation releases every UTXO held by reservationID, making
+// them selectable again.
+func (w *Wallet) CancelReservation(reservationID string) error {
+	raw := w.DB.Get(reservationKey(reservationID))
+	if raw == nil {
+		return errors.New("reservation not found")
+	}
+
+	res := &Reservation{}
+	if err := json.Unmarshal(raw, res); err != nil {
+		return errors.Wrap(err, "unmarshal reservation")
+	}
+
+	batch := w.DB.NewBatch()
+	batch.Delete(reservationKey(reservationID))
+	for _, outputID := range res.OutputIDs {
+		batch.Delete(reservedOutputKey(outputID))
+	}
+	batch.Write()
+	return nil
+}
+
+func (w *Wallet) isReserved(outputID string) bool {
+	rawID := w.DB.Get(reservedOutputKey(outputID))
+	if rawID == nil {
+		return false
+	}
+	res := &Reservation{}
+	if raw := w.DB.Get(reservationKey(string(rawID))); raw != nil && json.Unmarshal(raw, res) == nil {
+		if !res.ExpiresAt.IsZero() && time.Now().After(res.ExpiresAt) {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *Wallet) writeReservation(batch *db.Batch, accountID, assetID string, amount uint64, outputIDs []string, expiresAt time.Time) (*Reservation, error) {
+	res := &Reservation{
+		ID:        newReservationID(),
+		AccountID: accountID,
+		AssetID:   assetID,
+		Amount:    amount,
+		OutputIDs: outputIDs,
+		ExpiresAt: expiresAt,
+	}
+
+	rawRes, err := json.Marshal(res)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal reservation")
+	}
+	(*batch).Set(reservationKey(res.ID), rawRes)
+	for _, outputID := range outputIDs {
+		(*batch).Set(reservedOutputKey(outputID), []byte(res.ID))
+	}
+	return res, nil
+}
+
+// releaseReservation drops outputID from whichever reservation holds it;
+// called from BuildAccountUTXOs once the output is actually spent.
+func (w *Wallet) releaseReservation(batch *db.Batch, outputID bc.Hash) {
+	idxKey := reservedOutputKey(string(outputID.Bytes()))
+	rawID := w.DB.Get(idxKey)
+	if rawID == nil {
+		return
+	}
+	(*batch).Delete(idxKey)
+
+	id := string(rawID)
+	raw := w.DB.Get(reservationKey(id))
+	if raw == nil {
+		return
+	}
+
+	res := &Reservation{}
+	if err := json.Unmarshal(raw, res); err != nil {
+		log.WithField("err", err).Error("releasing reservation: unmarshal")
+		(*batch).Delete(reservationKey(id))
+		return
+	}
+
+	remaining := res.OutputIDs[:0]
+	for _, id := range res.OutputIDs {
+		if id != string(outputID.Bytes()) {
+			remaining = append(remaining, id)
+		}
+	}
+	if len(remaining) == 0 {
+		(*batch).Delete(reservationKey(res.ID))
+		return
+	}
+
+	res.OutputIDs = remaining
+	rawRes, err := json.Marshal(res)
+	if err != nil {
+		log.WithField("err", err).Error("releasing reservation: marshal")
+		return
+	}
+	(*batch).Set(reservationKey(res.ID), rawRes)
+}
+
+// promotePendingUTXO drops outputID's unconfirmed record once its block
+// has confirmed; upsertConfirmedAccountOutputs is already writing the
+// confirmed record in the same batch.
+func (w *Wallet) promotePendingUTXO(batch *db.Batch, outputID bc.Hash) {
+	key := pendingUTXOKey(string(outputID.Bytes()))
+	if w.DB.Get(key) == nil {
+		return
+	}
+	(*batch).Delete(key)
+}
+
+// demoteConfirmedUTXO runs when a reorg undoes a confirmed output. If it
+// was ever one of our own pending sends, it's written back unconfirmed.
+func (w *Wallet) demoteConfirmedUTXO(batch *db.Batch, u *account.UTXO) {
+	rawMeta := w.DB.Get(pendingMetaKey(string(u.OutputID)))
+	if rawMeta == nil {
+		return
+	}
+
+	meta := &pendingMeta{}
+	if err := json.Unmarshal(rawMeta, meta); err != nil {
+		log.WithField("err", err).Error("demoting confirmed utxo: unmarshal pending meta")
+		return
+	}
+
+	p := &PendingUTXO{
+		OutputID:       u.OutputID,
+		AccountID:      u.AccountID,
+		AssetID:        hex.EncodeToString(u.AssetID),
+		Amount:         u.Amount,
+		ControlProgram: u.Program,
+		Status:         query.StatusUnconfirmed,
+		SubmittedAt:    meta.SubmittedAt,
+		ExpiresAt:      meta.SubmittedAt.Add(pendingUTXODefaultTTL),
+	}
+	rawP, err := json.Marshal(p)
+	if err != nil {
+		log.WithField("err", err).Error("demoting confirmed utxo: marshal")
+		return
+	}
+	(*batch).Set(pendingUTXOKey(string(u.OutputID)), rawP)
+}
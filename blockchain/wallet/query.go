@@ -0,0 +1,92 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/json"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/bytom/blockchain/account"
+	"github.com/bytom/errors"
+)
+
+// ListUnspentOutputs returns up to limit unspent outputs held by accountID
+// in assetID, each with an amount no smaller than minAmount, ordered from
+// largest to smallest. It walks the accountUTXOIndex prefix directly, so
+// cost is proportional to the number of outputs returned rather than the
+// size of the whole UTXO set.
+func (w *Wallet) ListUnspentOutputs(accountID, assetID string, minAmount uint64, limit int) ([]*account.UTXO, error) {
+	if limit < 0 {
+		limit = 0
+	}
+	utxos := make([]*account.UTXO, 0, limit)
+
+	prefix := accountUTXOIndexPrefixKey(accountID, assetID)
+	iter := w.DB.IteratorPrefix(prefix)
+	defer iter.Release()
+
+	for iter.Next() && (limit <= 0 || len(utxos) < limit) {
+		amount := decodeDescendingAmount(iter.Key()[len(prefix) : len(prefix)+8])
+		if amount < minAmount {
+			break
+		}
+
+		outputID := iter.Value()
+		rawUTXO := w.DB.Get(account.AccountUTXOKey(string(outputID)))
+		if rawUTXO == nil {
+			//the UTXO was spent and the index entry hasn't caught up yet
+			continue
+		}
+
+		u := &account.UTXO{}
+		if err := json.Unmarshal(rawUTXO, u); err != nil {
+			log.WithField("err", err).Error("listing unspent outputs: unmarshal")
+			continue
+		}
+		utxos = append(utxos, u)
+	}
+
+	return utxos, nil
+}
+
+// GetAssetBalance returns the total unspent amount accountID holds of
+// assetID.
+func (w *Wallet) GetAssetBalance(accountID, assetID string) (uint64, error) {
+	var total uint64
+
+	prefix := accountUTXOIndexPrefixKey(accountID, assetID)
+	iter := w.DB.IteratorPrefix(prefix)
+	defer iter.Release()
+
+	for iter.Next() {
+		key := iter.Key()
+		if len(key) < len(prefix)+8 {
+			return 0, errors.New("malformed account utxo index key")
+		}
+		total += decodeDescendingAmount(key[len(prefix) : len(prefix)+8])
+	}
+
+	return total, nil
+}
+
+// GetBalance returns accountID's total unspent amount of every asset it
+// holds, keyed by asset ID.
+func (w *Wallet) GetBalance(accountID string) (map[string]uint64, error) {
+	balances := make(map[string]uint64)
+
+	prefix := accountUTXOIndexPrefixKey(accountID, "")
+	iter := w.DB.IteratorPrefix(prefix)
+	defer iter.Release()
+
+	for iter.Next() {
+		rest := iter.Key()[len(prefix):]
+		sep := bytes.IndexByte(rest, ':')
+		if sep < 0 || len(rest) < sep+1+8 {
+			return nil, errors.New("malformed account utxo index key")
+		}
+		assetID := string(rest[:sep])
+		balances[assetID] += decodeDescendingAmount(rest[sep+1 : sep+1+8])
+	}
+
+	return balances, nil
+}
@@ -0,0 +1,175 @@
+package wallet
+
+import (
+	"encoding/json"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/bytom/errors"
+)
+
+// rescanStatusKey is a single well-known key (not a prefix) since a
+// wallet only ever has one rescan in flight at a time.
+var rescanStatusKey = []byte("rescan_status")
+
+// RescanMode controls how much of the wallet's local state Rescan
+// rebuilds.
+type RescanMode int
+
+const (
+	//RescanFull rebuilds both the UTXO set and the annotated
+	//transaction history, for imported xpubs and seed restores.
+	RescanFull RescanMode = iota
+	//RescanAnnotationsOnly re-annotates historical transactions without
+	//touching the UTXO set, for schema upgrades to the annotated
+	//transaction format that don't change which coins the wallet owns.
+	RescanAnnotationsOnly
+)
+
+// RescanStatus is the checkpoint record Rescan persists after every
+// block, so a rescan survives a wallet restart and can be queried by RPC.
+type RescanStatus struct {
+	FromHeight    uint64     `json:"from_height"`
+	CurrentHeight uint64     `json:"current_height"`
+	TargetHeight  uint64     `json:"target_height"`
+	Mode          RescanMode `json:"mode"`
+	StartedAt     time.Time  `json:"started_at"`
+	Done          bool       `json:"done"`
+}
+
+// RescanOption configures a call to Rescan beyond the starting height.
+type RescanOption struct {
+	Mode RescanMode
+	//BlocksPerSec caps how many blocks Rescan processes per second so a
+	//rescan doesn't starve consensus of CPU and disk I/O. Zero means
+	//unthrottled.
+	BlocksPerSec int
+}
+
+// CancelRescan stops whichever rescan w currently has in progress, if any.
+func (w *Wallet) CancelRescan() {
+	w.rescanMu.Lock()
+	defer w.rescanMu.Unlock()
+	if w.rescanCancel != nil {
+		close(w.rescanCancel)
+		w.rescanCancel = nil
+	}
+}
+
+// RescanStatus returns the checkpoint of the most recently run (or
+// currently running) rescan, if one has ever been started.
+func (w *Wallet) RescanStatus() (*RescanStatus, error) {
+	raw := w.DB.Get(rescanStatusKey)
+	if raw == nil {
+		return nil, errors.New("no rescan has been started")
+	}
+
+	status := &RescanStatus{}
+	if err := json.Unmarshal(raw, status); err != nil {
+		return nil, errors.Wrap(err, "unmarshal rescan status")
+	}
+	return status, nil
+}
+
+// Rescan rebuilds the wallet's UTXO set and annotated transaction history
+// from fromHeight through the chain's current best height. Use this after
+// importing an xpub or restoring from seed, when the wallet has no record
+// of anything that happened before it started watching the chain.
+func (w *Wallet) Rescan(fromHeight uint64) error {
+	return w.RescanWithOptions(fromHeight, RescanOption{Mode: RescanFull})
+}
+
+// RescanWithOptions is Rescan with control over which parts of wallet
+// state get rebuilt and how fast the rescan is allowed to run. Progress
+// is checkpointed to rescan_status after every block, so a crash or
+// CancelRescan call can be resumed by calling Rescan again with the
+// checkpoint's CurrentHeight.
+func (w *Wallet) RescanWithOptions(fromHeight uint64, opt RescanOption) error {
+	w.rescanMu.Lock()
+	if w.rescanCancel != nil {
+		w.rescanMu.Unlock()
+		return errors.New("a rescan is already in progress")
+	}
+	cancel := make(chan struct{})
+	w.rescanCancel = cancel
+	w.rescanMu.Unlock()
+
+	defer func() {
+		w.rescanMu.Lock()
+		if w.rescanCancel == cancel {
+			w.rescanCancel = nil
+		}
+		w.rescanMu.Unlock()
+	}()
+
+	targetHeight := w.chain.BestBlockHeight()
+	status := &RescanStatus{
+		FromHeight:    fromHeight,
+		CurrentHeight: fromHeight,
+		TargetHeight:  targetHeight,
+		Mode:          opt.Mode,
+		StartedAt:     time.Now(),
+	}
+	if err := w.saveRescanStatus(status); err != nil {
+		return err
+	}
+
+	var throttle *time.Ticker
+	if opt.BlocksPerSec > 0 {
+		if interval := time.Second / time.Duration(opt.BlocksPerSec); interval > 0 {
+			throttle = time.NewTicker(interval)
+			defer throttle.Stop()
+		}
+	}
+
+	for height := fromHeight; height <= targetHeight; height++ {
+		select {
+		case <-cancel:
+			log.WithField("height", height).Info("wallet rescan cancelled")
+			return nil
+		default:
+		}
+
+		b, err := w.chain.GetBlockByHeight(height)
+		if err != nil {
+			return errors.Wrapf(err, "loading block %d for rescan", height)
+		}
+
+		if opt.Mode == RescanFull {
+			utxoBatch := w.DB.NewBatch()
+			w.BuildAccountUTXOs(&utxoBatch, b)
+			utxoBatch.Write()
+		}
+
+		//BuildAnnotatedTransaction reads back the UTXO/pending state
+		//BuildAccountUTXOs just wrote, so that batch must already be
+		//committed by the time this one runs.
+		batch := w.DB.NewBatch()
+		for i, tx := range b.Transactions {
+			w.BuildAnnotatedTransaction(&batch, tx, b, uint32(i))
+		}
+		batch.Write()
+
+		status.CurrentHeight = height
+		if err := w.saveRescanStatus(status); err != nil {
+			return err
+		}
+
+		if throttle != nil {
+			<-throttle.C
+		}
+	}
+
+	status.Done = true
+	return w.saveRescanStatus(status)
+}
+
+func (w *Wallet) saveRescanStatus(status *RescanStatus) error {
+	raw, err := json.Marshal(status)
+	if err != nil {
+		return errors.Wrap(err, "marshal rescan status")
+	}
+	w.DB.Set(rescanStatusKey, raw)
+	return nil
+}
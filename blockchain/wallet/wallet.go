@@ -0,0 +1,52 @@
+package wallet
+
+import (
+	"sync"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/tendermint/tmlibs/db"
+
+	"github.com/bytom/protocol/bc/legacy"
+)
+
+// chainReader is the slice of protocol.Chain that Rescan needs to walk
+// historical blocks.
+type chainReader interface {
+	BestBlockHeight() uint64
+	GetBlockByHeight(height uint64) (*legacy.Block, error)
+}
+
+// Wallet indexes account UTXOs, pending transactions, and annotated
+// transaction history off of the blocks the chain delivers it.
+type Wallet struct {
+	DB    db.DB
+	chain chainReader
+
+	cpFilter *cpFilter
+
+	accountCache *lru.Cache
+	assetCache   *lru.Cache
+
+	rescanMu     sync.Mutex
+	rescanCancel chan struct{}
+
+	//reserveMu serializes ReserveUTXOs's select-then-mark sequence.
+	reserveMu sync.Mutex
+}
+
+// NewWallet creates a wallet backed by db, seeding its control-program
+// Bloom filter from controlPrograms.
+func NewWallet(db db.DB, chain chainReader, controlPrograms [][]byte) *Wallet {
+	w := &Wallet{
+		DB:    db,
+		chain: chain,
+	}
+
+	accountCache, _ := lru.New(annotationCacheSize)
+	assetCache, _ := lru.New(annotationCacheSize)
+	w.accountCache = accountCache
+	w.assetCache = assetCache
+
+	w.RebuildCPFilter(controlPrograms)
+	return w
+}